@@ -0,0 +1,178 @@
+// Package health tracks the liveness of the smart plugs a manager.Manager is
+// responsible for, modeled loosely on Tailscale's health.Tracker: subsystems
+// report state transitions into a Tracker, the Tracker notifies any
+// registered watchers, and the accumulated view can be rendered as JSON for
+// an operator-facing /healthz endpoint.
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// State is the health state of a single managed plug.
+type State string
+
+const (
+	// StateOnline indicates the plug answered its most recent poll.
+	StateOnline State = "online"
+	// StateOffline indicates the plug is in the manager's disconnected set.
+	StateOffline State = "offline"
+	// StateMismatch indicates the plug answered but its last-observed state
+	// does not match the desired state.
+	StateMismatch State = "state-mismatch"
+	// StateDiscoveryFailing indicates the most recent discovery() scan
+	// intended to recover this plug returned an error.
+	StateDiscoveryFailing State = "discovery-failing"
+)
+
+// Watcher is called whenever a label's State transitions. It is not called
+// on every poll, only when the new state differs from the previous one.
+type Watcher func(label string, state State, err error)
+
+// plugHealth is the last-known health of a single managed plug.
+type plugHealth struct {
+	state       State
+	err         error
+	lastSuccess time.Time
+}
+
+// Tracker aggregates per-plug health state and global warnings for a
+// manager.Manager. The zero value is not usable; construct with NewTracker.
+// A Tracker is safe for concurrent use.
+type Tracker struct {
+	mu       sync.Mutex
+	plugs    map[string]*plugHealth
+	watchers []Watcher
+}
+
+// NewTracker returns an empty, ready to use Tracker.
+func NewTracker() *Tracker {
+	return &Tracker{
+		plugs: make(map[string]*plugHealth),
+	}
+}
+
+// RegisterWatcher registers w to be called, from the goroutine that observed
+// the transition, whenever a plug's State changes.
+func (t *Tracker) RegisterWatcher(w Watcher) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.watchers = append(t.watchers, w)
+}
+
+// SetState records the current health of label, firing any registered
+// watchers if state differs from the previously recorded state. err is
+// retained as the most recent error observed for label and is cleared once
+// label reports StateOnline.
+func (t *Tracker) SetState(label string, state State, err error) {
+	t.mu.Lock()
+	p, ok := t.plugs[label]
+	if !ok {
+		p = &plugHealth{}
+		t.plugs[label] = p
+	}
+	changed := !ok || p.state != state
+	p.state = state
+	p.err = err
+	if state == StateOnline {
+		p.lastSuccess = time.Now()
+	}
+	watchers := t.watchers
+	t.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	for _, w := range watchers {
+		w(label, state, err)
+	}
+}
+
+// Forget removes label from the Tracker entirely, e.g. because it was
+// dropped from the set of managed labels.
+func (t *Tracker) Forget(label string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.plugs, label)
+}
+
+// plugView is the JSON-serializable view of a single plug's health.
+type plugView struct {
+	State       State     `json:"state"`
+	Error       string    `json:"error,omitempty"`
+	LastSuccess time.Time `json:"lastSuccess,omitempty"`
+}
+
+// view is the JSON-serializable view served at /healthz.
+type view struct {
+	Plugs    map[string]plugView `json:"plugs"`
+	Warnings []string            `json:"warnings,omitempty"`
+}
+
+// disconnectWarningThreshold is how long a plug must remain offline before
+// it is called out as a global warning rather than just a per-plug state.
+const disconnectWarningThreshold = 5 * time.Minute
+
+// Snapshot returns the current per-plug states and a list of human-readable
+// global warnings, such as "N plugs disconnected for > 5m0s".
+func (t *Tracker) Snapshot() (map[string]State, []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	states := make(map[string]State, len(t.plugs))
+	disconnected := 0
+	for label, p := range t.plugs {
+		states[label] = p.state
+		unreachable := p.state == StateOffline || p.state == StateDiscoveryFailing
+		if unreachable && !p.lastSuccess.IsZero() && time.Since(p.lastSuccess) > disconnectWarningThreshold {
+			disconnected++
+		}
+	}
+
+	var warnings []string
+	if disconnected > 0 {
+		warnings = append(warnings, pluralDisconnectWarning(disconnected, disconnectWarningThreshold))
+	}
+	return states, warnings
+}
+
+func pluralDisconnectWarning(n int, threshold time.Duration) string {
+	if n == 1 {
+		return "1 plug disconnected for > " + threshold.String()
+	}
+	return strconv.Itoa(n) + " plugs disconnected for > " + threshold.String()
+}
+
+// ServeHTTP renders the current health snapshot as JSON, suitable for
+// mounting at /healthz on an operator-facing HTTP server.
+func (t *Tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.mu.Lock()
+	plugs := make(map[string]plugView, len(t.plugs))
+	disconnected := 0
+	for label, p := range t.plugs {
+		pv := plugView{State: p.state, LastSuccess: p.lastSuccess}
+		if p.err != nil {
+			pv.Error = p.err.Error()
+		}
+		plugs[label] = pv
+		unreachable := p.state == StateOffline || p.state == StateDiscoveryFailing
+		if unreachable && !p.lastSuccess.IsZero() && time.Since(p.lastSuccess) > disconnectWarningThreshold {
+			disconnected++
+		}
+	}
+	t.mu.Unlock()
+
+	v := view{Plugs: plugs}
+	if disconnected > 0 {
+		v.Warnings = append(v.Warnings, pluralDisconnectWarning(disconnected, disconnectWarningThreshold))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
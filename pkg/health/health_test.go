@@ -0,0 +1,146 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// transition records one watcher invocation.
+type transition struct {
+	label string
+	state State
+	err   error
+}
+
+func TestSetStateFiresWatcherOnlyOnTransition(t *testing.T) {
+	tr := NewTracker()
+
+	var got []transition
+	tr.RegisterWatcher(func(label string, state State, err error) {
+		got = append(got, transition{label, state, err})
+	})
+
+	tr.SetState("plug1", StateOnline, nil)
+	tr.SetState("plug1", StateOnline, nil) // repeat: no transition, watcher must not fire again
+	tr.SetState("plug1", StateOffline, errors.New("timeout"))
+	tr.SetState("plug1", StateOffline, errors.New("timeout")) // repeat again
+
+	want := []transition{
+		{"plug1", StateOnline, nil},
+		{"plug1", StateOffline, errors.New("timeout")},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("watcher fired %d times, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i].label != want[i].label || got[i].state != want[i].state {
+			t.Errorf("transition %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestForgetRemovesPlug(t *testing.T) {
+	tr := NewTracker()
+	tr.SetState("plug1", StateOnline, nil)
+	tr.Forget("plug1")
+
+	states, _ := tr.Snapshot()
+	if _, ok := states["plug1"]; ok {
+		t.Errorf("Snapshot still reports forgotten label %q", "plug1")
+	}
+}
+
+func TestSnapshotNoWarningBelowThreshold(t *testing.T) {
+	tr := NewTracker()
+	tr.SetState("plug1", StateOnline, nil)
+	tr.SetState("plug1", StateOffline, errors.New("down"))
+
+	_, warnings := tr.Snapshot()
+	if len(warnings) != 0 {
+		t.Errorf("warnings = %v, want none (disconnect is fresh)", warnings)
+	}
+}
+
+func TestSnapshotWarnsPastThreshold(t *testing.T) {
+	tr := NewTracker()
+	tr.SetState("plug1", StateOnline, nil)
+	tr.SetState("plug2", StateOnline, nil)
+
+	// Same package: backdate lastSuccess directly rather than sleeping 5m in a test.
+	tr.mu.Lock()
+	tr.plugs["plug1"].lastSuccess = time.Now().Add(-2 * disconnectWarningThreshold)
+	tr.plugs["plug2"].lastSuccess = time.Now().Add(-2 * disconnectWarningThreshold)
+	tr.mu.Unlock()
+
+	tr.SetState("plug1", StateOffline, errors.New("down"))
+	tr.SetState("plug2", StateOffline, errors.New("down"))
+
+	states, warnings := tr.Snapshot()
+	if states["plug1"] != StateOffline || states["plug2"] != StateOffline {
+		t.Fatalf("states = %v, want both offline", states)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("warnings = %v, want exactly one", warnings)
+	}
+	want := "2 plugs disconnected for > " + disconnectWarningThreshold.String()
+	if warnings[0] != want {
+		t.Errorf("warnings[0] = %q, want %q", warnings[0], want)
+	}
+}
+
+func TestSnapshotWarnsPastThresholdForDiscoveryFailing(t *testing.T) {
+	tr := NewTracker()
+	tr.SetState("plug1", StateOnline, nil)
+
+	tr.mu.Lock()
+	tr.plugs["plug1"].lastSuccess = time.Now().Add(-2 * disconnectWarningThreshold)
+	tr.mu.Unlock()
+
+	tr.SetState("plug1", StateDiscoveryFailing, errors.New("discover: no route to host"))
+
+	states, warnings := tr.Snapshot()
+	if states["plug1"] != StateDiscoveryFailing {
+		t.Fatalf("states[plug1] = %v, want %v", states["plug1"], StateDiscoveryFailing)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("warnings = %v, want exactly one (discovery-failing still counts as disconnected)", warnings)
+	}
+}
+
+func TestPluralDisconnectWarningSingular(t *testing.T) {
+	if got := pluralDisconnectWarning(1, disconnectWarningThreshold); got != "1 plug disconnected for > "+disconnectWarningThreshold.String() {
+		t.Errorf("pluralDisconnectWarning(1, ...) = %q", got)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	tr := NewTracker()
+	tr.SetState("plug1", StateOnline, nil)
+	tr.SetState("plug2", StateMismatch, errors.New("desired on, last off"))
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	rec := httptest.NewRecorder()
+	tr.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+
+	var v view
+	if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+		t.Fatalf("decoding response: %s", err)
+	}
+	if v.Plugs["plug1"].State != StateOnline {
+		t.Errorf("plug1 state = %q, want %q", v.Plugs["plug1"].State, StateOnline)
+	}
+	if v.Plugs["plug2"].State != StateMismatch || v.Plugs["plug2"].Error == "" {
+		t.Errorf("plug2 = %+v, want state %q with a non-empty error", v.Plugs["plug2"], StateMismatch)
+	}
+	if len(v.Warnings) != 0 {
+		t.Errorf("warnings = %v, want none", v.Warnings)
+	}
+}
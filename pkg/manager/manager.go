@@ -4,11 +4,19 @@ package manager
 
 import (
 	"context"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/jaedle/golang-tplink-hs100/pkg/configuration"
 	"github.com/jaedle/golang-tplink-hs100/pkg/hs100"
+
+	"github.com/electronjoe/tplink-hs100-manager/pkg/health"
+	"github.com/electronjoe/tplink-hs100-manager/pkg/manager/metrics"
+	"github.com/electronjoe/tplink-hs100-manager/pkg/schedule"
+	"github.com/electronjoe/tplink-hs100-manager/pkg/store"
 )
 
 // Manager is responsible for maintaining cached state of network connected smart plugs, periodically polling to re-validate both plug presence and state (e.g. on/off).
@@ -32,6 +40,11 @@ type Manager struct {
 	manageLabels map[string]struct{}
 	// pollingInterval indicates how regularly we poll all smart plugs to check online status and confirm state.
 	pollingInterval time.Duration
+	// pollConcurrency bounds how many plugs updateState polls at once.
+	pollConcurrency int
+	// store is the persistent backing for desiredStates. It is the source
+	// of truth; desiredStates is the in-memory cache Run keeps in sync with it.
+	store store.DesiredStateStore
 
 	/////////
 	// The following are mutable state protected by stateMutex.
@@ -47,71 +60,437 @@ type Manager struct {
 	smartplugs map[string]*hs100.Hs100
 	// disconnected presents a set of smartplug labels which need reconnect.
 	disconnected map[string]struct{}
+	// reconnectStates holds per-label reconnect backoff and last-known
+	// address, indexed by label. Only entries for currently or
+	// previously disconnected labels are present.
+	reconnectStates map[string]*reconnectState
+
+	// health tracks per-plug online/offline/mismatch state and notifies
+	// watchers on transitions. See the health package for detail.
+	health *health.Tracker
+
+	// subs holds every current Subscribe caller. Subscribers are identified
+	// by their *subscriber pointer, not the caller-supplied name (which
+	// is for logging only and is not required to be unique), so two
+	// Subscribe calls with the same name can't be confused with each
+	// other on cancel. See store.FileStore's watchers slice for the same
+	// pattern.
+	subs []*subscriber
+
+	// metrics exports plug state and reconcile counters/histograms as
+	// Prometheus collectors. See the metrics package for detail.
+	metrics *metrics.Collector
+
+	// schedule fires SetDesiredState at cron-style and solar-relative times.
+	// Nil if New was called with a nil scheduleStore. See the schedule
+	// package for detail.
+	schedule *schedule.Scheduler
+}
+
+// subscriberBufferSize bounds how many StateChanges a subscriber may lag
+// behind before updateState starts dropping rather than blocking on it.
+const subscriberBufferSize = 16
+
+// subscriber is the bookkeeping behind a single Subscribe call.
+type subscriber struct {
+	name    string // caller-supplied, for logging only; not unique
+	ch      chan StateChange
+	dropped uint64
+}
+
+// StateChange describes a transition in a managed plug's state, delivered
+// to Subscribe callers.
+type StateChange struct {
+	Label   string
+	Desired bool
+	Last    bool
+	Online  bool
+	At      time.Time
 }
 
-// New generates a new HS100 smart plug manager.
-func (*Manager) New(discovery func() ([]*hs100.Hs100, error), manageLabels map[string]struct{}, pollingInterval time.Duration) *Manager {
+// CancelFunc stops a Subscribe subscription and closes its channel.
+type CancelFunc func()
+
+// defaultPollConcurrency is used when New is passed a pollConcurrency <= 0.
+const defaultPollConcurrency = 8
+
+// initialReconnectBackoff is the delay applied after a label's first failed
+// reconnect attempt; it doubles on each subsequent failure, capped at
+// pollingInterval.
+const initialReconnectBackoff = 1 * time.Second
+
+// New generates a new HS100 smart plug manager. desiredStateStore is the
+// persistent backing for desired on/off state; see the store package for
+// file-backed and etcd-backed implementations. Labels already present in
+// desiredStateStore seed the Manager's initial desired state. pollConcurrency
+// bounds how many plugs are polled at once; pollConcurrency <= 0 uses
+// defaultPollConcurrency. scheduleStore enables the cron-style/solar schedule
+// subsystem (see AddSchedule); pass nil to disable it, in which case
+// solarProvider is ignored. solarProvider resolves sunrise/sunset for
+// solar-relative schedule rules and may be nil if none are added.
+func (*Manager) New(discovery func() ([]*hs100.Hs100, error), manageLabels map[string]struct{}, pollingInterval time.Duration, desiredStateStore store.DesiredStateStore, pollConcurrency int, scheduleStore store.ScheduleStore, solarProvider schedule.SolarProvider) *Manager {
 	smartplugs := make(map[string]*hs100.Hs100, len(manageLabels))
 	desiredStates := make(map[string]bool, len(manageLabels))
 	lastStates := make(map[string]bool, len(manageLabels))
 	disconnected := make(map[string]struct{})
 
-	return &Manager{
+	for label := range manageLabels {
+		if on, ok := desiredStateStore.Get(label); ok {
+			desiredStates[label] = on
+		}
+	}
+
+	if pollConcurrency <= 0 {
+		pollConcurrency = defaultPollConcurrency
+	}
+
+	m := &Manager{
 		discovery:       discovery,
 		manageLabels:    manageLabels,
 		desiredStates:   desiredStates,
 		lastStates:      lastStates,
 		pollingInterval: pollingInterval,
+		pollConcurrency: pollConcurrency,
 		smartplugs:      smartplugs,
 		disconnected:    disconnected,
+		reconnectStates: make(map[string]*reconnectState),
+		store:           desiredStateStore,
+		health:          health.NewTracker(),
+	}
+	m.metrics = metrics.NewCollector(m.Snapshot)
+
+	if scheduleStore != nil {
+		sched, err := schedule.NewScheduler(scheduleStore, solarProvider, m.SetDesiredState)
+		if err != nil {
+			glog.Warningf("Schedule subsystem disabled: %s", err)
+		} else {
+			m.schedule = sched
+		}
+	}
+
+	return m
+}
+
+// AddSchedule adds or replaces a cron-style or solar-relative rule that sets label's desired
+// state to on whenever expr fires; see schedule.Scheduler.AddSchedule for expr's grammar. It
+// returns an error if the schedule subsystem was not enabled (see New's scheduleStore parameter).
+func (m *Manager) AddSchedule(label, expr string, on bool) error {
+	if m.schedule == nil {
+		return fmt.Errorf("schedule subsystem is not enabled; pass a non-nil scheduleStore to New")
+	}
+	return m.schedule.AddSchedule(label, expr, on)
+}
+
+// Pause suppresses label's scheduled flips until until, without deleting its schedule rule. It is
+// a no-op if the schedule subsystem was not enabled.
+func (m *Manager) Pause(label string, until time.Time) {
+	if m.schedule != nil {
+		m.schedule.Pause(label, until)
+	}
+}
+
+// Snapshot returns a lock-consistent view of desiredStates, lastStates, and plug
+// online/disconnected status, for the metrics package's Prometheus collectors to read without
+// tearing.
+func (m *Manager) Snapshot() metrics.Snapshot {
+	m.stateMutex.Lock()
+	defer m.stateMutex.Unlock()
+
+	desired := make(map[string]bool, len(m.desiredStates))
+	for label, on := range m.desiredStates {
+		desired[label] = on
+	}
+	last := make(map[string]bool, len(m.lastStates))
+	for label, on := range m.lastStates {
+		last[label] = on
+	}
+	online := make(map[string]bool, len(m.smartplugs))
+	for label := range m.smartplugs {
+		online[label] = true
+	}
+	disconnected := make(map[string]struct{}, len(m.disconnected))
+	for label := range m.disconnected {
+		disconnected[label] = struct{}{}
+	}
+
+	return metrics.Snapshot{
+		DesiredStates: desired,
+		LastStates:    last,
+		Online:        online,
+		Disconnected:  disconnected,
+	}
+}
+
+// Metrics returns the Manager's metrics.Collector, for registration with a Prometheus registry
+// and serving at /metrics.
+func (m *Manager) Metrics() *metrics.Collector {
+	return m.metrics
+}
+
+// Health returns the Manager's health.Tracker, which reports per-plug
+// online/offline/state-mismatch/discovery-failing state and can be served
+// at /healthz for dashboards and alerting.
+func (m *Manager) Health() *health.Tracker {
+	return m.health
+}
+
+// SetDesiredState records that label should be on (or off), persisting the
+// change through the Manager's DesiredStateStore. The new desired state
+// takes effect as soon as the store's Watch event reaches watchDesiredState,
+// which also triggers an immediate reconcile.
+func (m *Manager) SetDesiredState(label string, on bool) error {
+	if _, ok := m.manageLabels[label]; !ok {
+		return fmt.Errorf("label %q is not managed by this Manager", label)
+	}
+	return m.store.Set(label, on)
+}
+
+// GetState reports label's desired state, last-observed state, and whether
+// the plug is currently online (present in smartplugs, not disconnected).
+func (m *Manager) GetState(label string) (desired, last, online bool, err error) {
+	if _, ok := m.manageLabels[label]; !ok {
+		return false, false, false, fmt.Errorf("label %q is not managed by this Manager", label)
+	}
+
+	m.stateMutex.Lock()
+	defer m.stateMutex.Unlock()
+	desired = m.desiredStates[label]
+	last = m.lastStates[label]
+	_, online = m.smartplugs[label]
+	return desired, last, online, nil
+}
+
+// Subscribe registers a new subscription named name (used only for logging;
+// callers may reuse the same name across multiple Subscribe calls without
+// the subscriptions being confused with each other), returning a channel of
+// StateChanges and a CancelFunc to stop the subscription. The channel is
+// bounded; a subscriber that falls behind has StateChanges dropped for it
+// rather than stalling reconciliation for everyone else.
+func (m *Manager) Subscribe(name string) (<-chan StateChange, CancelFunc) {
+	sub := &subscriber{name: name, ch: make(chan StateChange, subscriberBufferSize)}
+
+	m.stateMutex.Lock()
+	m.subs = append(m.subs, sub)
+	m.stateMutex.Unlock()
+
+	cancel := func() {
+		m.stateMutex.Lock()
+		defer m.stateMutex.Unlock()
+		for i, s := range m.subs {
+			if s == sub {
+				m.subs = append(m.subs[:i], m.subs[i+1:]...)
+				break
+			}
+		}
+		close(sub.ch)
+	}
+	return sub.ch, cancel
+}
+
+// publishStateChange delivers sc to every current subscriber without
+// blocking: a subscriber whose buffer is full has sc dropped and its
+// drop counter incremented instead of stalling the caller. stateMutex is
+// held for the whole send loop, like store.FileStore's notify, so a
+// concurrent cancel can't close a subscriber's channel out from under an
+// in-flight send.
+func (m *Manager) publishStateChange(sc StateChange) {
+	m.stateMutex.Lock()
+	defer m.stateMutex.Unlock()
+
+	for _, sub := range m.subs {
+		select {
+		case sub.ch <- sc:
+		default:
+			dropped := atomic.AddUint64(&sub.dropped, 1)
+			glog.Warningf("Subscriber buffer full, dropping StateChange for label %q (dropped so far: %d)", sc.Label, dropped)
+		}
+	}
+}
+
+// reconnectState is the per-label bookkeeping behind attemptReconnect's
+// backoff and last-known-address tracking.
+type reconnectState struct {
+	// lastAddr is the address the plug last answered on, tried directly
+	// before falling back to a full discovery() scan. Empty until the
+	// plug has been seen at least once.
+	lastAddr string
+	// backoff is the delay applied after the most recent failed attempt;
+	// it doubles on each consecutive failure, capped at pollingInterval.
+	backoff time.Duration
+	// nextAttempt is when this label is next eligible for a reconnect
+	// attempt. The zero value means "eligible immediately".
+	nextAttempt time.Time
+}
+
+// reconnectStateLocked returns name's reconnectState, creating it if
+// absent. Callers must hold m.stateMutex.
+func (m *Manager) reconnectStateLocked(name string) *reconnectState {
+	rs, ok := m.reconnectStates[name]
+	if !ok {
+		rs = &reconnectState{}
+		m.reconnectStates[name] = rs
 	}
+	return rs
+}
+
+// recordReconnectFailure marks name's most recent reconnect attempt (direct
+// or via discovery) as failed, doubling its backoff up to pollingInterval,
+// and records state in the health tracker. state is ordinarily
+// health.StateOffline; callers whose failure was a discovery() scan error
+// rather than a poll or direct-reconnect failure pass
+// health.StateDiscoveryFailing instead, so operators can tell the two apart.
+func (m *Manager) recordReconnectFailure(name string, err error, state health.State) {
+	m.stateMutex.Lock()
+	rs := m.reconnectStateLocked(name)
+	if rs.backoff == 0 {
+		rs.backoff = initialReconnectBackoff
+	} else if rs.backoff *= 2; rs.backoff > m.pollingInterval {
+		rs.backoff = m.pollingInterval
+	}
+	rs.nextAttempt = time.Now().Add(rs.backoff)
+	m.stateMutex.Unlock()
+
+	m.health.SetState(name, state, err)
+}
+
+// resetReconnectState clears name's backoff after a successful reconnect.
+func (m *Manager) resetReconnectState(name string) {
+	m.stateMutex.Lock()
+	delete(m.reconnectStates, name)
+	m.stateMutex.Unlock()
 }
 
-// attemptReconnect scans through discoverable plugs and moves any discovered m.disconnected plugs to m.smartplugs.
+// attemptReconnect tries to restore every m.disconnected label whose backoff
+// has elapsed. Each label is first tried directly against its last-known
+// address; labels for which that fails are serviced by a single coalesced
+// discovery() scan rather than one scan per label.
 func (m *Manager) attemptReconnect() {
-	if len(m.disconnected) == 0 {
+	now := time.Now()
+
+	m.stateMutex.Lock()
+	due := make([]string, 0, len(m.disconnected))
+	for name := range m.disconnected {
+		if !now.Before(m.reconnectStateLocked(name).nextAttempt) {
+			due = append(due, name)
+		}
+	}
+	m.stateMutex.Unlock()
+
+	if len(due) == 0 {
 		return
 	}
 
+	needsDiscovery := make([]string, 0, len(due))
+	for _, name := range due {
+		if !m.tryDirectReconnect(name) {
+			needsDiscovery = append(needsDiscovery, name)
+		}
+	}
+
+	if len(needsDiscovery) > 0 {
+		m.reconnectViaDiscovery(needsDiscovery)
+	}
+}
+
+// tryDirectReconnect attempts to reconnect to name at its last-known
+// address without a full discovery() scan, reporting whether it succeeded.
+func (m *Manager) tryDirectReconnect(name string) bool {
+	m.stateMutex.Lock()
+	addr := m.reconnectStateLocked(name).lastAddr
+	m.stateMutex.Unlock()
+	if addr == "" {
+		return false
+	}
+
+	plug := hs100.NewHs100(addr, configuration.Default())
+	if _, err := plug.IsOn(); err != nil {
+		m.metrics.IncReconcileError("isOn")
+		m.recordReconnectFailure(name, err, health.StateOffline)
+		return false
+	}
+
+	m.admitSmartplugIfDisconnected(name, plug)
+	m.resetReconnectState(name)
+	return true
+}
+
+// reconnectViaDiscovery services every label in names with a single
+// discovery() scan, so N disconnected plugs cost one scan rather than N.
+func (m *Manager) reconnectViaDiscovery(names []string) {
+	start := time.Now()
 	discoveredPlugs, err := m.discovery()
+	m.metrics.ObserveDiscoveryDuration(time.Since(start))
 	if err != nil {
-		// TODO: should this be Fatal, or might we recover?
-		glog.Fatalf("Failed in hs100.Discover, err: %s\n", err)
+		m.metrics.IncReconcileError("discover")
+		glog.Warningf("Discovery scan servicing %d disconnected plug(s) failed with err: %s", len(names), err)
+		for _, name := range names {
+			m.recordReconnectFailure(name, err, health.StateDiscoveryFailing)
+		}
+		return
 	}
 
+	recovered := make(map[string]struct{}, len(discoveredPlugs))
 	for _, plug := range discoveredPlugs {
 		name, err := plug.GetName()
 		if err != nil {
-			glog.Warningf("Getname on smartplug with address %q failed with err: %s, skipping smartplug", d.Address, err)
+			glog.Warningf("Getname on smartplug with address %q failed with err: %s, skipping smartplug", plug.Address, err)
 			continue
 		}
 
-		m.admitSmartplugIfDisconnected(name, plug)
+		if admitted := m.admitSmartplugIfDisconnected(name, plug); admitted {
+			m.stateMutex.Lock()
+			m.reconnectStateLocked(name).lastAddr = plug.Address
+			m.stateMutex.Unlock()
+			m.resetReconnectState(name)
+			recovered[name] = struct{}{}
+		}
+	}
+
+	for _, name := range names {
+		if _, ok := recovered[name]; !ok {
+			m.recordReconnectFailure(name, fmt.Errorf("label %q not found in discovery scan", name), health.StateOffline)
+		}
 	}
 }
 
-// admitSmartplugIfDisconnected moves a plug from the disconnected set to the smartplug set if present in the disconnected set.
-func (m *Manager) admitSmartplugIfDisconnected(name string, plug *hs100.Hs100) {
+// admitSmartplugIfDisconnected moves a plug from the disconnected set to the smartplug set if present in the disconnected set, reporting whether it did so.
+func (m *Manager) admitSmartplugIfDisconnected(name string, plug *hs100.Hs100) bool {
 	m.stateMutex.Lock()
-	defer m.stateMutex.Unlock()
-	if _, ok := m.disconnected[name]; ok {
+	_, wasDisconnected := m.disconnected[name]
+	if wasDisconnected {
 		// Found a disconnected device! Add to healthy set.
 		delete(m.disconnected, name)
 		m.smartplugs[name] = plug
+		m.health.SetState(name, health.StateOnline, nil)
 	}
+	sc := StateChange{Label: name, Desired: m.desiredStates[name], Last: m.lastStates[name], Online: true, At: time.Now()}
+	m.stateMutex.Unlock()
+
+	if wasDisconnected {
+		m.publishStateChange(sc)
+	}
+	return wasDisconnected
 }
 
 // Run is a blocking function which is used to poll the state of all managed smart outlets, monitor their presence and re-apply their state if inconsistent.
 func (m *Manager) Run(ctx context.Context) {
 	ticker := time.NewTicker(m.pollingInterval)
 
+	go m.watchDesiredState(ctx)
+	if m.schedule != nil {
+		go m.schedule.Run(ctx)
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case _ = <-ticker.C:
-			if len(m.disconnected) != 0 {
-				glog.V(4).Info("Attempting to reconnect to disconnected set of plugs %v.", m.disconnected)
+			m.stateMutex.Lock()
+			haveDisconnected := len(m.disconnected) != 0
+			m.stateMutex.Unlock()
+			if haveDisconnected {
+				glog.V(4).Info("Attempting to reconnect to disconnected set of plugs.")
 				m.attemptReconnect()
 			}
 			glog.V(4).Info("Validating health andstate of smart plugs, applying desired state.")
@@ -120,29 +499,119 @@ func (m *Manager) Run(ctx context.Context) {
 	}
 }
 
-// updateState cycles through the healthy connected plugs lisetd in smartplugs, updates their cached state (on / off) and aligns them with desired state (updates on / off if necessary). Any failures on access of the smartplug causes the plug to be removed from smartplugs and added by label to the disconnected set (where periodic attemptReconnect() will try to restore health by re-discovery of the plug by label).
+// watchDesiredState fans events from m.store's Watch channel into
+// desiredStates, so that a desired-state change made by this or another
+// Manager instance is reflected immediately rather than on the next
+// pollingInterval tick. It returns once ctx is done.
+func (m *Manager) watchDesiredState(ctx context.Context) {
+	for ev := range m.store.Watch(ctx) {
+		m.stateMutex.Lock()
+		m.desiredStates[ev.Label] = ev.On
+		m.stateMutex.Unlock()
+
+		glog.V(4).Infof("Desired state for %q changed to %v via store watch, reconciling now.", ev.Label, ev.On)
+		m.updateState()
+	}
+}
+
+// updateState polls every healthy connected plug in smartplugs concurrently, through a worker
+// pool bounded by pollConcurrency, updating cached state (on / off) and aligning it with desired
+// state. A failed IsOn poll causes the plug to be removed from smartplugs and added by label to
+// the disconnected set (where periodic attemptReconnect() will try to restore health, first by
+// direct reconnect to its last address, then by re-discovery); a failed TurnOn/TurnOff leaves
+// the plug connected (see pollOne).
 func (m *Manager) updateState() {
-	// Gather state of the plugs, collect slice of plugs that are inaccessible
-	tryReconnectNames := make([]string, 0, len(m.smartplugs))
+	m.stateMutex.Lock()
+	plugs := make(map[string]*hs100.Hs100, len(m.smartplugs))
 	for name, plug := range m.smartplugs {
-		isOn, err := plug.IsOn()
-		if err != nil {
-			glog.Warningf("IsOn on smartplug with label %q, address %q failed with err: %s", name, plug.Address, err)
-			m.stateMutex.Lock()
-			defer m.stateMutex.Unlock()
-			tryReconnectNames = append(tryReconnectNames, name)
-			delete(m.smartplugs, name)
-			continue
-		}
+		plugs[name] = plug
+	}
+	m.stateMutex.Unlock()
 
-		// Protect modification by lock use
-		{
-			m.stateMutex.Lock()
-			defer m.stateMutex.Unlock()
-			m.lastStates[name] = isOn
+	sem := make(chan struct{}, m.pollConcurrency)
+	var wg sync.WaitGroup
+	for name, plug := range plugs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(name string, plug *hs100.Hs100) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			m.pollOne(name, plug)
+		}(name, plug)
+	}
+	wg.Wait()
+
+	m.metrics.IncReconcile()
+}
+
+// pollOne polls a single plug's on/off state, issuing a TurnOn/TurnOff call to correct it if it
+// differs from the plug's desired state, and reconciles the outcome with the Manager's
+// bookkeeping. A failed IsOn moves the plug to the disconnected set; a failed TurnOn/TurnOff
+// leaves it connected but reports health.StateMismatch with the error.
+func (m *Manager) pollOne(name string, plug *hs100.Hs100) {
+	start := time.Now()
+	isOn, err := plug.IsOn()
+	m.metrics.ObservePollDuration(name, time.Since(start))
+	if err != nil {
+		m.metrics.IncReconcileError("isOn")
+		glog.Warningf("IsOn on smartplug with label %q, address %q failed with err: %s", name, plug.Address, err)
+		m.stateMutex.Lock()
+		delete(m.smartplugs, name)
+		m.disconnected[name] = struct{}{}
+		m.reconnectStateLocked(name).lastAddr = plug.Address
+		sc := StateChange{Label: name, Desired: m.desiredStates[name], Last: m.lastStates[name], Online: false, At: time.Now()}
+		m.stateMutex.Unlock()
+
+		// name was in m.smartplugs, so it was online until now: this is
+		// always an online->offline transition.
+		m.recordReconnectFailure(name, err, health.StateOffline)
+		m.publishStateChange(sc)
+		return
+	}
+
+	m.stateMutex.Lock()
+	prevLast, hadPrev := m.lastStates[name]
+	desired, hasDesired := m.desiredStates[name]
+	m.stateMutex.Unlock()
+
+	last := isOn
+	var applyErr error
+	if hasDesired && desired != isOn {
+		if applyErr = m.applyDesiredState(name, plug, desired); applyErr == nil {
+			last = desired
 		}
-		// desiredStates[name] = false
 	}
 
-	// Attempt to reconnect to any failing plugs
+	m.stateMutex.Lock()
+	m.lastStates[name] = last
+	if applyErr != nil {
+		m.health.SetState(name, health.StateMismatch, applyErr)
+	} else {
+		m.health.SetState(name, health.StateOnline, nil)
+	}
+	sc := StateChange{Label: name, Desired: m.desiredStates[name], Last: last, Online: true, At: time.Now()}
+	transitioned := !hadPrev || prevLast != last
+	m.stateMutex.Unlock()
+
+	if transitioned {
+		m.publishStateChange(sc)
+	}
+}
+
+// applyDesiredState issues a TurnOn or TurnOff call to plug to bring it in line with desired,
+// recording the outcome as a "turnOn"/"turnOff" reconcile error metric and glog line on failure.
+func (m *Manager) applyDesiredState(name string, plug *hs100.Hs100, desired bool) error {
+	op := "turnOff"
+	var err error
+	if desired {
+		op = "turnOn"
+		err = plug.TurnOn()
+	} else {
+		err = plug.TurnOff()
+	}
+	if err != nil {
+		m.metrics.IncReconcileError(op)
+		glog.Warningf("%s on smartplug with label %q, address %q failed with err: %s", op, name, plug.Address, err)
+	}
+	return err
 }
@@ -0,0 +1,262 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaedle/golang-tplink-hs100/pkg/hs100"
+
+	"github.com/electronjoe/tplink-hs100-manager/pkg/health"
+	"github.com/electronjoe/tplink-hs100-manager/pkg/store"
+)
+
+// fakeCommandSender is a minimal hs100.CommandSender that reports a fixed
+// relay state and records TurnOn/TurnOff calls, so pollOne can be exercised
+// against a *hs100.Hs100 without a real device on the network.
+type fakeCommandSender struct {
+	mu       sync.Mutex
+	relayOn  bool
+	turnedOn int
+}
+
+func (f *fakeCommandSender) SendCommand(address, command string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case strings.Contains(command, "get_sysinfo"):
+		state := 0
+		if f.relayOn {
+			state = 1
+		}
+		return fmt.Sprintf(`{"system":{"get_sysinfo":{"relay_state":%d,"alias":"plug1","deviceId":"plug1"}}}`, state), nil
+	case strings.Contains(command, `"set_relay_state":{"state":1}`):
+		f.relayOn = true
+		f.turnedOn++
+		return `{"system":{"set_relay_state":{"err_code":0}}}`, nil
+	case strings.Contains(command, `"set_relay_state":{"state":0}`):
+		f.relayOn = false
+		return `{"system":{"set_relay_state":{"err_code":0}}}`, nil
+	default:
+		return "", fmt.Errorf("fakeCommandSender: unrecognized command %q", command)
+	}
+}
+
+// fakeStore is a minimal in-memory store.DesiredStateStore for tests that
+// don't exercise persistence.
+type fakeStore struct {
+	mu     sync.Mutex
+	states map[string]bool
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{states: make(map[string]bool)}
+}
+
+func (fs *fakeStore) Get(label string) (bool, bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	on, ok := fs.states[label]
+	return on, ok
+}
+
+func (fs *fakeStore) List() map[string]bool {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	out := make(map[string]bool, len(fs.states))
+	for k, v := range fs.states {
+		out[k] = v
+	}
+	return out
+}
+
+func (fs *fakeStore) Set(label string, on bool) error {
+	fs.mu.Lock()
+	fs.states[label] = on
+	fs.mu.Unlock()
+	return nil
+}
+
+func (fs *fakeStore) Watch(ctx context.Context) <-chan store.Event {
+	ch := make(chan store.Event)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch
+}
+
+func newTestManager() *Manager {
+	discovery := func() ([]*hs100.Hs100, error) { return nil, nil }
+	return (&Manager{}).New(discovery, map[string]struct{}{"plug1": {}}, time.Minute, newFakeStore(), 1, nil, nil)
+}
+
+// TestSubscribeCancelConcurrentPublish exercises cancel() racing with a
+// concurrent publishStateChange for the same subscriber: cancel closes the
+// subscriber's channel, and publishStateChange must never attempt to send
+// on it afterwards, or on a channel it is about to close. Run with -race.
+func TestSubscribeCancelConcurrentPublish(t *testing.T) {
+	m := newTestManager()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		ch, cancel := m.Subscribe("sub")
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for range ch {
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				m.publishStateChange(StateChange{Label: "plug1", At: time.Now()})
+			}
+			cancel()
+		}()
+		wg.Wait()
+	}
+}
+
+// TestSubscribeSameNameTwiceDoesNotCrossCancel checks that two Subscribe
+// calls sharing the same caller-supplied name are tracked independently: if
+// they were keyed by name instead of identity, the first subscription's
+// cancel would delete the second's map entry and silently starve it forever.
+func TestSubscribeSameNameTwiceDoesNotCrossCancel(t *testing.T) {
+	m := newTestManager()
+
+	ch1, cancel1 := m.Subscribe("dup")
+	ch2, cancel2 := m.Subscribe("dup")
+	defer cancel2()
+
+	cancel1()
+	if _, ok := <-ch1; ok {
+		t.Fatalf("ch1 still open after cancel1")
+	}
+
+	m.publishStateChange(StateChange{Label: "plug1", At: time.Now()})
+	select {
+	case sc, ok := <-ch2:
+		if !ok {
+			t.Fatalf("ch2 closed; cancel1 must not have affected the second Subscribe(\"dup\")")
+		}
+		if sc.Label != "plug1" {
+			t.Errorf("StateChange.Label = %q, want %q", sc.Label, "plug1")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ch2 never received the StateChange published after cancel1")
+	}
+}
+
+// TestRecordReconnectFailureBackoff checks that recordReconnectFailure
+// doubles a label's backoff on each consecutive call, starting at
+// initialReconnectBackoff, and caps it at pollingInterval.
+func TestRecordReconnectFailureBackoff(t *testing.T) {
+	m := newTestManager()
+	m.pollingInterval = 10 * time.Second
+
+	wantBackoffs := []time.Duration{
+		1 * time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		10 * time.Second, // capped at pollingInterval
+		10 * time.Second,
+	}
+
+	for i, want := range wantBackoffs {
+		m.recordReconnectFailure("plug1", nil, health.StateOffline)
+
+		m.stateMutex.Lock()
+		got := m.reconnectStateLocked("plug1").backoff
+		m.stateMutex.Unlock()
+
+		if got != want {
+			t.Errorf("after %d failure(s): backoff = %s, want %s", i+1, got, want)
+		}
+	}
+}
+
+// TestResetReconnectStateClearsBackoff checks that a successful reconnect
+// forgets a label's backoff so a later failure restarts at
+// initialReconnectBackoff rather than resuming a capped backoff.
+func TestResetReconnectStateClearsBackoff(t *testing.T) {
+	m := newTestManager()
+	m.pollingInterval = 10 * time.Second
+
+	m.recordReconnectFailure("plug1", nil, health.StateOffline)
+	m.recordReconnectFailure("plug1", nil, health.StateOffline)
+	m.resetReconnectState("plug1")
+	m.recordReconnectFailure("plug1", nil, health.StateOffline)
+
+	m.stateMutex.Lock()
+	got := m.reconnectStateLocked("plug1").backoff
+	m.stateMutex.Unlock()
+
+	if want := initialReconnectBackoff; got != want {
+		t.Errorf("backoff after reset and one failure = %s, want %s", got, want)
+	}
+}
+
+// TestSetDesiredStateTriggersImmediateReconcileViaWatch exercises the full
+// path Run wires up: SetDesiredState persists through a real store.FileStore,
+// whose Watch event is fanned into desiredStates by watchDesiredState, which
+// immediately calls updateState rather than waiting for the poll ticker. A
+// Subscribe caller must observe the resulting StateChange well before
+// pollingInterval elapses.
+func TestSetDesiredStateTriggersImmediateReconcileViaWatch(t *testing.T) {
+	fileStore, err := store.NewFileStore(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("NewFileStore: %s", err)
+	}
+
+	sender := &fakeCommandSender{relayOn: false}
+	discovery := func() ([]*hs100.Hs100, error) { return nil, nil }
+	m := (&Manager{}).New(discovery, map[string]struct{}{"plug1": {}}, time.Hour, fileStore, 1, nil, nil)
+	m.smartplugs["plug1"] = hs100.NewHs100("10.0.0.1", sender)
+
+	ch, cancel := m.Subscribe("sub")
+	defer cancel()
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go m.watchDesiredState(ctx)
+
+	// watchDesiredState's Watch registration races with this goroutine's
+	// startup, so resend SetDesiredState until it lands on a registered
+	// watcher rather than failing on a single unlucky scheduling.
+	deadline := time.After(time.Second)
+	retry := time.NewTicker(10 * time.Millisecond)
+	defer retry.Stop()
+	if err := m.SetDesiredState("plug1", true); err != nil {
+		t.Fatalf("SetDesiredState: %s", err)
+	}
+
+	var sc StateChange
+	for {
+		select {
+		case sc = <-ch:
+		case <-retry.C:
+			if err := m.SetDesiredState("plug1", true); err != nil {
+				t.Fatalf("SetDesiredState: %s", err)
+			}
+			continue
+		case <-deadline:
+			t.Fatal("no StateChange observed within 1s of SetDesiredState; watchDesiredState did not reconcile immediately")
+		}
+		break
+	}
+
+	if sc.Label != "plug1" || !sc.Desired || !sc.Last || !sc.Online {
+		t.Errorf("StateChange = %+v, want plug1 on, last on, online", sc)
+	}
+	if sender.turnedOn == 0 {
+		t.Errorf("TurnOn never called")
+	}
+}
@@ -0,0 +1,134 @@
+// Package metrics exports a manager.Manager's plug state and reconcile loop as Prometheus
+// collectors, for mounting at /metrics on an operator-facing HTTP server.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Snapshot is a lock-consistent view of a Manager's plug bookkeeping, as produced by
+// Manager.Snapshot(). Collect pulls a fresh Snapshot on every scrape so the exported gauges never
+// tear mid-read.
+type Snapshot struct {
+	// DesiredStates holds the desired on/off state of each managed plug, by label.
+	DesiredStates map[string]bool
+	// LastStates holds the most recently polled on/off state of each managed plug, by label.
+	LastStates map[string]bool
+	// Online holds, for each plug currently believed reachable, a true value.
+	Online map[string]bool
+	// Disconnected holds the set of plug labels currently awaiting reconnect.
+	Disconnected map[string]struct{}
+}
+
+// SnapshotFunc supplies a fresh Snapshot on demand, ordinarily manager.Manager.Snapshot.
+type SnapshotFunc func() Snapshot
+
+// Collector is a prometheus.Collector that exports per-plug desired/last/online gauges pulled
+// from a Manager's Snapshot on every scrape, plus reconcile counters and duration histograms
+// pushed inline by the Manager as it polls and discovers.
+type Collector struct {
+	snapshot SnapshotFunc
+
+	reconcileTotal       prometheus.Counter
+	reconcileErrorsTotal *prometheus.CounterVec
+	pollDuration         *prometheus.HistogramVec
+	discoveryDuration    prometheus.Histogram
+
+	desiredDesc *prometheus.Desc
+	lastDesc    *prometheus.Desc
+	onlineDesc  *prometheus.Desc
+}
+
+// NewCollector returns a Collector that pulls plug state from snapshot on every scrape.
+func NewCollector(snapshot SnapshotFunc) *Collector {
+	return &Collector{
+		snapshot: snapshot,
+
+		reconcileTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "tplink_reconcile_total",
+			Help: "Total number of reconcile passes over managed plugs.",
+		}),
+		reconcileErrorsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tplink_reconcile_errors_total",
+			Help: "Total number of errors encountered while reconciling plugs, by operation.",
+		}, []string{"op"}),
+		pollDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "tplink_poll_duration_seconds",
+			Help: "Duration of a single plug's IsOn poll.",
+		}, []string{"label"}),
+		discoveryDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name: "tplink_discovery_duration_seconds",
+			Help: "Duration of a discovery() scan.",
+		}),
+
+		desiredDesc: prometheus.NewDesc("tplink_plug_desired_state", "Desired on/off state of a managed plug (1=on).", []string{"label"}, nil),
+		lastDesc:    prometheus.NewDesc("tplink_plug_last_state", "Last-observed on/off state of a managed plug (1=on).", []string{"label"}, nil),
+		onlineDesc:  prometheus.NewDesc("tplink_plug_online", "Whether a managed plug answered its most recent poll (1=online).", []string{"label"}, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desiredDesc
+	ch <- c.lastDesc
+	ch <- c.onlineDesc
+	c.reconcileTotal.Describe(ch)
+	c.reconcileErrorsTotal.Describe(ch)
+	c.pollDuration.Describe(ch)
+	c.discoveryDuration.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	snap := c.snapshot()
+
+	for label, on := range snap.DesiredStates {
+		ch <- prometheus.MustNewConstMetric(c.desiredDesc, prometheus.GaugeValue, boolToFloat(on), label)
+	}
+	for label, on := range snap.LastStates {
+		ch <- prometheus.MustNewConstMetric(c.lastDesc, prometheus.GaugeValue, boolToFloat(on), label)
+	}
+	for label, online := range snap.Online {
+		ch <- prometheus.MustNewConstMetric(c.onlineDesc, prometheus.GaugeValue, boolToFloat(online), label)
+	}
+	for label := range snap.Disconnected {
+		if _, ok := snap.Online[label]; !ok {
+			ch <- prometheus.MustNewConstMetric(c.onlineDesc, prometheus.GaugeValue, 0, label)
+		}
+	}
+
+	c.reconcileTotal.Collect(ch)
+	c.reconcileErrorsTotal.Collect(ch)
+	c.pollDuration.Collect(ch)
+	c.discoveryDuration.Collect(ch)
+}
+
+// IncReconcile records that one reconcile pass over managed plugs completed.
+func (c *Collector) IncReconcile() {
+	c.reconcileTotal.Inc()
+}
+
+// IncReconcileError records a failed operation of the given kind, e.g. "isOn", "turnOn",
+// "turnOff", or "discover".
+func (c *Collector) IncReconcileError(op string) {
+	c.reconcileErrorsTotal.WithLabelValues(op).Inc()
+}
+
+// ObservePollDuration records how long a single plug's IsOn poll took.
+func (c *Collector) ObservePollDuration(label string, d time.Duration) {
+	c.pollDuration.WithLabelValues(label).Observe(d.Seconds())
+}
+
+// ObserveDiscoveryDuration records how long a discovery() scan took.
+func (c *Collector) ObserveDiscoveryDuration(d time.Duration) {
+	c.discoveryDuration.Observe(d.Seconds())
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollectExportsPlugStateGauges(t *testing.T) {
+	snap := Snapshot{
+		DesiredStates: map[string]bool{"porch-light": true, "fan": false},
+		LastStates:    map[string]bool{"porch-light": true, "fan": false},
+		Online:        map[string]bool{"porch-light": true},
+		Disconnected:  map[string]struct{}{"fan": {}},
+	}
+	c := NewCollector(func() Snapshot { return snap })
+
+	want := `
+# HELP tplink_plug_desired_state Desired on/off state of a managed plug (1=on).
+# TYPE tplink_plug_desired_state gauge
+tplink_plug_desired_state{label="fan"} 0
+tplink_plug_desired_state{label="porch-light"} 1
+# HELP tplink_plug_last_state Last-observed on/off state of a managed plug (1=on).
+# TYPE tplink_plug_last_state gauge
+tplink_plug_last_state{label="fan"} 0
+tplink_plug_last_state{label="porch-light"} 1
+# HELP tplink_plug_online Whether a managed plug answered its most recent poll (1=online).
+# TYPE tplink_plug_online gauge
+tplink_plug_online{label="fan"} 0
+tplink_plug_online{label="porch-light"} 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want),
+		"tplink_plug_desired_state", "tplink_plug_last_state", "tplink_plug_online"); err != nil {
+		t.Fatalf("unexpected collected metrics: %s", err)
+	}
+}
+
+// TestCollectReportsDisconnectedAsOfflineWithoutOnlineEntry guards the case where a plug is
+// known-disconnected but has no entry at all in snap.Online (rather than an explicit false):
+// Collect must still emit an explicit online=0 sample for it.
+func TestCollectReportsDisconnectedAsOfflineWithoutOnlineEntry(t *testing.T) {
+	snap := Snapshot{
+		Online:       map[string]bool{},
+		Disconnected: map[string]struct{}{"fan": {}},
+	}
+	c := NewCollector(func() Snapshot { return snap })
+
+	want := `
+# HELP tplink_plug_online Whether a managed plug answered its most recent poll (1=online).
+# TYPE tplink_plug_online gauge
+tplink_plug_online{label="fan"} 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "tplink_plug_online"); err != nil {
+		t.Fatalf("unexpected collected metrics: %s", err)
+	}
+}
+
+func TestIncAndObserveMethodsMoveUnderlyingCollectors(t *testing.T) {
+	c := NewCollector(func() Snapshot { return Snapshot{} })
+
+	c.IncReconcile()
+	c.IncReconcile()
+	if got := testutil.ToFloat64(c.reconcileTotal); got != 2 {
+		t.Errorf("reconcileTotal = %v, want 2", got)
+	}
+
+	c.IncReconcileError("isOn")
+	c.IncReconcileError("isOn")
+	c.IncReconcileError("turnOn")
+	if got := testutil.ToFloat64(c.reconcileErrorsTotal.WithLabelValues("isOn")); got != 2 {
+		t.Errorf("reconcileErrorsTotal{op=isOn} = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(c.reconcileErrorsTotal.WithLabelValues("turnOn")); got != 1 {
+		t.Errorf("reconcileErrorsTotal{op=turnOn} = %v, want 1", got)
+	}
+
+	c.ObservePollDuration("porch-light", 250*time.Millisecond)
+	if got := testutil.CollectAndCount(c.pollDuration); got != 1 {
+		t.Errorf("pollDuration sample count = %d, want 1", got)
+	}
+
+	c.ObserveDiscoveryDuration(2 * time.Second)
+	if got := testutil.CollectAndCount(c.discoveryDuration); got != 1 {
+		t.Errorf("discoveryDuration sample count = %d, want 1", got)
+	}
+}
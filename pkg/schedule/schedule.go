@@ -0,0 +1,326 @@
+// Package schedule implements cron-style and solar-relative time-based rules that flip a
+// manager.Manager's desired plug state, e.g. turning the porch light on at 17:30 daily or 30
+// minutes before sunset.
+package schedule
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/robfig/cron/v3"
+
+	"github.com/electronjoe/tplink-hs100-manager/pkg/store"
+)
+
+// SolarProvider resolves sunrise/sunset times for a fixed lat/long, used to schedule rules
+// relative to "sunset" or "sunrise" rather than a fixed clock time.
+type SolarProvider interface {
+	// NextSunset returns the first sunset strictly after t.
+	NextSunset(t time.Time) (time.Time, error)
+	// NextSunrise returns the first sunrise strictly after t.
+	NextSunrise(t time.Time) (time.Time, error)
+}
+
+// Apply is called at each rule's fire time to enact its desired state; ordinarily
+// manager.Manager.SetDesiredState.
+type Apply func(label string, on bool) error
+
+// cronParser parses 6-field, seconds-resolution cron expressions (e.g. "0 30 17 * * *").
+var cronParser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
+
+// rule is the parsed, heap-scheduled form of one store.ScheduleRule.
+type rule struct {
+	store.ScheduleRule
+	nextFire time.Time
+	index    int // heap index, maintained by container/heap
+}
+
+// ruleHeap is a min-heap of *rule ordered by nextFire.
+type ruleHeap []*rule
+
+func (h ruleHeap) Len() int            { return len(h) }
+func (h ruleHeap) Less(i, j int) bool  { return h[i].nextFire.Before(h[j].nextFire) }
+func (h ruleHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i]; h[i].index = i; h[j].index = j }
+func (h *ruleHeap) Push(x interface{}) {
+	r := x.(*rule)
+	r.index = len(*h)
+	*h = append(*h, r)
+}
+func (h *ruleHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	r := old[n-1]
+	old[n-1] = nil
+	r.index = -1
+	*h = old[:n-1]
+	return r
+}
+
+// Scheduler fires Apply calls at times described by cron-style and solar-relative rules, backed
+// by a store.ScheduleStore so rules survive restart. Create with NewScheduler; start with Run.
+type Scheduler struct {
+	store store.ScheduleStore
+	solar SolarProvider
+	apply Apply
+
+	mu          sync.Mutex
+	rules       map[string]*rule // by label
+	pending     ruleHeap
+	pausedUntil map[string]time.Time
+
+	wake chan struct{}
+}
+
+// NewScheduler loads any rules already in scheduleStore and returns a ready-to-Run Scheduler.
+// solar may be nil if no rules use solar-relative expressions.
+func NewScheduler(scheduleStore store.ScheduleStore, solar SolarProvider, apply Apply) (*Scheduler, error) {
+	s := &Scheduler{
+		store:       scheduleStore,
+		solar:       solar,
+		apply:       apply,
+		rules:       make(map[string]*rule),
+		pausedUntil: make(map[string]time.Time),
+		wake:        make(chan struct{}, 1),
+	}
+
+	existing, err := scheduleStore.List()
+	if err != nil {
+		return nil, fmt.Errorf("loading schedule rules: %w", err)
+	}
+	s.mu.Lock()
+	for _, sr := range existing {
+		if err := s.addRuleLocked(sr, time.Now()); err != nil {
+			glog.Warningf("Schedule: skipping persisted rule for label %q, expr %q: %s", sr.Label, sr.Expr, err)
+		}
+	}
+	s.mu.Unlock()
+	return s, nil
+}
+
+// AddSchedule adds or replaces the schedule rule for label, persisting it so it survives
+// restart. expr is either a 6-field, seconds-resolution cron expression (e.g. "0 30 17 * * *")
+// or a solar-relative expression of the form "sunset±DURATION" / "sunrise±DURATION", e.g.
+// "sunset-30m" to fire half an hour before sunset.
+func (s *Scheduler) AddSchedule(label, expr string, on bool) error {
+	sr := store.ScheduleRule{Label: label, Expr: expr, On: on}
+
+	s.mu.Lock()
+	err := s.addRuleLocked(sr, time.Now())
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.Set(sr); err != nil {
+		return fmt.Errorf("persisting schedule rule for label %q: %w", label, err)
+	}
+	s.notifyWake()
+	return nil
+}
+
+// Pause suppresses label's scheduled flips until until, without deleting its rule. The
+// suppression lapses on its own the next time label's rule fires after until passes; no separate
+// call is needed to resume.
+func (s *Scheduler) Pause(label string, until time.Time) {
+	s.mu.Lock()
+	s.pausedUntil[label] = until
+	s.mu.Unlock()
+}
+
+// Run blocks, firing Apply for each rule as its schedule comes due, until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		s.mu.Lock()
+		wait := s.nextWaitLocked()
+		s.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+			continue
+		case <-timer.C:
+			s.fireDue()
+		}
+	}
+}
+
+// idlePollInterval bounds how long Run sleeps when no rule is scheduled, so a rule added via
+// AddSchedule concurrently with a nearly-expired timer is still picked up promptly via wake.
+const idlePollInterval = time.Hour
+
+// nextWaitLocked returns how long to sleep before the next rule is due. Callers must hold s.mu.
+func (s *Scheduler) nextWaitLocked() time.Duration {
+	if len(s.pending) == 0 {
+		return idlePollInterval
+	}
+	if wait := time.Until(s.pending[0].nextFire); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// fireDue applies every rule whose nextFire has passed, then reschedules it.
+func (s *Scheduler) fireDue() {
+	now := time.Now()
+
+	s.mu.Lock()
+	var due []*rule
+	for len(s.pending) > 0 && !s.pending[0].nextFire.After(now) {
+		due = append(due, heap.Pop(&s.pending).(*rule))
+	}
+	s.mu.Unlock()
+
+	for _, r := range due {
+		s.fireOne(r, now)
+	}
+}
+
+// fireOne applies r's desired state unless r.Label is currently paused, then computes and
+// schedules r's next fire time.
+func (s *Scheduler) fireOne(r *rule, now time.Time) {
+	s.mu.Lock()
+	pausedUntil, paused := s.pausedUntil[r.Label]
+	if paused && !now.Before(pausedUntil) {
+		delete(s.pausedUntil, r.Label) // suppression lapsed
+		paused = false
+	}
+	s.mu.Unlock()
+
+	if paused {
+		glog.V(4).Infof("Schedule rule for label %q fired but is paused until %s, skipping.", r.Label, pausedUntil)
+	} else if err := s.apply(r.Label, r.On); err != nil {
+		glog.Warningf("Schedule: applying desired state %v for label %q failed with err: %s", r.On, r.Label, err)
+	}
+
+	next, err := s.resolveNext(r.ScheduleRule, now)
+	if err != nil {
+		glog.Warningf("Schedule: failed to compute next fire time for label %q, dropping rule: %s", r.Label, err)
+		s.mu.Lock()
+		if s.rules[r.Label] == r {
+			delete(s.rules, r.Label)
+		}
+		s.mu.Unlock()
+		return
+	}
+
+	r.nextFire = next
+	s.mu.Lock()
+	if s.rules[r.Label] == r {
+		// r is still the live rule for this label: nothing superseded it while apply was
+		// in flight, so reschedule it. If AddSchedule replaced it in the meantime, r is
+		// stale and must be dropped rather than re-pushed alongside the replacement.
+		heap.Push(&s.pending, r)
+	}
+	s.mu.Unlock()
+}
+
+// addRuleLocked parses sr, schedules its first fire time after `after`, and replaces any
+// existing rule for the same label. Callers must hold s.mu.
+func (s *Scheduler) addRuleLocked(sr store.ScheduleRule, after time.Time) error {
+	next, err := s.resolveNext(sr, after)
+	if err != nil {
+		return err
+	}
+
+	if old, ok := s.rules[sr.Label]; ok && old.index >= 0 {
+		heap.Remove(&s.pending, old.index)
+	}
+
+	r := &rule{ScheduleRule: sr, nextFire: next}
+	s.rules[sr.Label] = r
+	heap.Push(&s.pending, r)
+	return nil
+}
+
+// resolveNext computes sr's next fire time strictly after `after`, parsing sr.Expr as either a
+// cron expression or a solar-relative expression.
+func (s *Scheduler) resolveNext(sr store.ScheduleRule, after time.Time) (time.Time, error) {
+	if isSolarExpr(sr.Expr) {
+		return s.resolveNextSolar(sr.Expr, after)
+	}
+
+	cronSchedule, err := cronParser.Parse(sr.Expr)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing cron expression %q: %w", sr.Expr, err)
+	}
+	return cronSchedule.Next(after), nil
+}
+
+// resolveNextSolar computes the next fire time for a solar-relative expression such as
+// "sunset-30m", retrying against the following solar event if the offset would otherwise fire
+// before `after`.
+func (s *Scheduler) resolveNextSolar(expr string, after time.Time) (time.Time, error) {
+	if s.solar == nil {
+		return time.Time{}, fmt.Errorf("solar expression %q requires a SolarProvider", expr)
+	}
+	event, offset, err := parseSolarExpr(expr)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	base := after
+	for attempt := 0; attempt < 2; attempt++ {
+		var solarTime time.Time
+		if event == "sunset" {
+			solarTime, err = s.solar.NextSunset(base)
+		} else {
+			solarTime, err = s.solar.NextSunrise(base)
+		}
+		if err != nil {
+			return time.Time{}, fmt.Errorf("resolving next %s: %w", event, err)
+		}
+
+		next := solarTime.Add(offset)
+		if next.After(after) {
+			return next, nil
+		}
+		base = solarTime
+	}
+	return time.Time{}, fmt.Errorf("could not resolve a fire time after %s for solar expression %q", after, expr)
+}
+
+// isSolarExpr reports whether expr is a solar-relative expression rather than a cron expression.
+func isSolarExpr(expr string) bool {
+	return strings.HasPrefix(expr, "sunset") || strings.HasPrefix(expr, "sunrise")
+}
+
+// parseSolarExpr splits a solar-relative expression into its event ("sunset" or "sunrise") and
+// offset, e.g. "sunset-30m" -> ("sunset", -30m), "sunrise" -> ("sunrise", 0).
+func parseSolarExpr(expr string) (event string, offset time.Duration, err error) {
+	switch {
+	case strings.HasPrefix(expr, "sunset"):
+		event, expr = "sunset", strings.TrimPrefix(expr, "sunset")
+	case strings.HasPrefix(expr, "sunrise"):
+		event, expr = "sunrise", strings.TrimPrefix(expr, "sunrise")
+	default:
+		return "", 0, fmt.Errorf("not a solar expression: %q", expr)
+	}
+
+	if expr == "" {
+		return event, 0, nil
+	}
+	// time.ParseDuration doesn't accept a leading "+"; strip it before parsing.
+	offset, err = time.ParseDuration(strings.TrimPrefix(expr, "+"))
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid solar offset %q: %w", expr, err)
+	}
+	return event, offset, nil
+}
+
+// notifyWake nudges a running Run loop to recompute its sleep duration immediately, e.g. because
+// AddSchedule just scheduled a fire time earlier than whatever Run was already waiting on.
+func (s *Scheduler) notifyWake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,55 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSolarExpr(t *testing.T) {
+	tests := []struct {
+		expr       string
+		wantEvent  string
+		wantOffset time.Duration
+		wantErr    bool
+	}{
+		{expr: "sunset", wantEvent: "sunset", wantOffset: 0},
+		{expr: "sunrise", wantEvent: "sunrise", wantOffset: 0},
+		{expr: "sunset-30m", wantEvent: "sunset", wantOffset: -30 * time.Minute},
+		{expr: "sunrise+1h", wantEvent: "sunrise", wantOffset: time.Hour},
+		{expr: "sunset+15m", wantEvent: "sunset", wantOffset: 15 * time.Minute},
+		{expr: "midnight", wantErr: true},
+		{expr: "sunset-notaduration", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		event, offset, err := parseSolarExpr(tt.expr)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseSolarExpr(%q): want error, got nil", tt.expr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSolarExpr(%q): unexpected error: %s", tt.expr, err)
+			continue
+		}
+		if event != tt.wantEvent || offset != tt.wantOffset {
+			t.Errorf("parseSolarExpr(%q) = (%q, %s), want (%q, %s)", tt.expr, event, offset, tt.wantEvent, tt.wantOffset)
+		}
+	}
+}
+
+func TestIsSolarExpr(t *testing.T) {
+	tests := map[string]bool{
+		"sunset":        true,
+		"sunrise+1h":    true,
+		"sunset-30m":    true,
+		"0 30 17 * * *": false,
+		"* * * * * *":   false,
+	}
+	for expr, want := range tests {
+		if got := isSolarExpr(expr); got != want {
+			t.Errorf("isSolarExpr(%q) = %v, want %v", expr, got, want)
+		}
+	}
+}
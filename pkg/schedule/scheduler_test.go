@@ -0,0 +1,291 @@
+package schedule
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/electronjoe/tplink-hs100-manager/pkg/store"
+)
+
+// fakeScheduleStore is a minimal in-memory store.ScheduleStore for tests.
+type fakeScheduleStore struct {
+	mu    sync.Mutex
+	rules map[string]store.ScheduleRule
+}
+
+func newFakeScheduleStore(initial ...store.ScheduleRule) *fakeScheduleStore {
+	fs := &fakeScheduleStore{rules: make(map[string]store.ScheduleRule)}
+	for _, r := range initial {
+		fs.rules[r.Label] = r
+	}
+	return fs
+}
+
+func (fs *fakeScheduleStore) List() ([]store.ScheduleRule, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	out := make([]store.ScheduleRule, 0, len(fs.rules))
+	for _, r := range fs.rules {
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+func (fs *fakeScheduleStore) Set(rule store.ScheduleRule) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.rules[rule.Label] = rule
+	return nil
+}
+
+func (fs *fakeScheduleStore) Delete(label string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	delete(fs.rules, label)
+	return nil
+}
+
+// fakeSolarProvider returns sunrise/sunset times at a fixed offset from the queried time, so
+// tests get deterministic, controllable fire times without depending on cron parsing.
+type fakeSolarProvider struct {
+	sunsetOffset, sunriseOffset time.Duration
+}
+
+func (p fakeSolarProvider) NextSunset(t time.Time) (time.Time, error) {
+	return t.Add(p.sunsetOffset), nil
+}
+
+func (p fakeSolarProvider) NextSunrise(t time.Time) (time.Time, error) {
+	return t.Add(p.sunriseOffset), nil
+}
+
+// recordingApply is an Apply that records every call it receives.
+type recordingApply struct {
+	mu    sync.Mutex
+	calls []store.ScheduleRule
+}
+
+func (r *recordingApply) apply(label string, on bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, store.ScheduleRule{Label: label, On: on})
+	return nil
+}
+
+func (r *recordingApply) callCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.calls)
+}
+
+func TestNewSchedulerLoadsPersistedRules(t *testing.T) {
+	persisted := newFakeScheduleStore(
+		store.ScheduleRule{Label: "porch-light", Expr: "sunset-30m", On: true},
+		store.ScheduleRule{Label: "fan", Expr: "sunrise", On: false},
+	)
+	apply := &recordingApply{}
+	solar := fakeSolarProvider{sunsetOffset: time.Hour, sunriseOffset: 2 * time.Hour}
+
+	s, err := NewScheduler(persisted, solar, apply.apply)
+	if err != nil {
+		t.Fatalf("NewScheduler: %s", err)
+	}
+
+	if got := len(s.rules); got != 2 {
+		t.Errorf("len(rules) = %d, want 2", got)
+	}
+	if got := len(s.pending); got != 2 {
+		t.Errorf("len(pending) = %d, want 2", got)
+	}
+}
+
+func TestAddScheduleReplacesExistingRule(t *testing.T) {
+	persisted := newFakeScheduleStore()
+	apply := &recordingApply{}
+	solar := fakeSolarProvider{sunsetOffset: time.Hour}
+
+	s, err := NewScheduler(persisted, solar, apply.apply)
+	if err != nil {
+		t.Fatalf("NewScheduler: %s", err)
+	}
+
+	if err := s.AddSchedule("porch-light", "sunset-30m", true); err != nil {
+		t.Fatalf("AddSchedule: %s", err)
+	}
+	if err := s.AddSchedule("porch-light", "sunset+15m", false); err != nil {
+		t.Fatalf("AddSchedule (replace): %s", err)
+	}
+
+	s.mu.Lock()
+	numRules := len(s.rules)
+	numPending := len(s.pending)
+	r := s.rules["porch-light"]
+	s.mu.Unlock()
+
+	if numRules != 1 {
+		t.Errorf("len(rules) = %d, want 1 (replace, not append)", numRules)
+	}
+	if numPending != 1 {
+		t.Errorf("len(pending) = %d, want 1 (old heap entry removed)", numPending)
+	}
+	if r.Expr != "sunset+15m" || r.On != false {
+		t.Errorf("rules[porch-light] = %+v, want Expr sunset+15m, On false", r.ScheduleRule)
+	}
+
+	persistedRules, _ := persisted.List()
+	if len(persistedRules) != 1 {
+		t.Errorf("persisted rule count = %d, want 1", len(persistedRules))
+	}
+}
+
+func TestFireOneSkipsWhilePausedAndAppliesAfterLapse(t *testing.T) {
+	apply := &recordingApply{}
+	solar := fakeSolarProvider{sunsetOffset: time.Hour}
+	s, err := NewScheduler(newFakeScheduleStore(), solar, apply.apply)
+	if err != nil {
+		t.Fatalf("NewScheduler: %s", err)
+	}
+
+	now := time.Now()
+	sr := store.ScheduleRule{Label: "porch-light", Expr: "sunset", On: true}
+	s.mu.Lock()
+	if err := s.addRuleLocked(sr, now); err != nil {
+		s.mu.Unlock()
+		t.Fatalf("addRuleLocked: %s", err)
+	}
+	r := s.rules["porch-light"]
+	s.mu.Unlock()
+
+	s.Pause("porch-light", now.Add(time.Hour))
+	s.fireOne(r, now)
+	if got := apply.callCount(); got != 0 {
+		t.Fatalf("apply called %d times while paused, want 0", got)
+	}
+
+	// Suppression lapses once `now` is at or past pausedUntil.
+	s.fireOne(r, now.Add(2*time.Hour))
+	if got := apply.callCount(); got != 1 {
+		t.Fatalf("apply called %d times after pause lapsed, want 1", got)
+	}
+}
+
+func TestFireDueFiresDueRulesAndReschedules(t *testing.T) {
+	apply := &recordingApply{}
+	solar := fakeSolarProvider{sunsetOffset: time.Hour}
+	s, err := NewScheduler(newFakeScheduleStore(), solar, apply.apply)
+	if err != nil {
+		t.Fatalf("NewScheduler: %s", err)
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	// due is scheduled in the past relative to `now`, notDue is an hour out.
+	due := &rule{ScheduleRule: store.ScheduleRule{Label: "due", Expr: "sunset", On: true}, nextFire: now.Add(-time.Minute)}
+	notDue := &rule{ScheduleRule: store.ScheduleRule{Label: "not-due", Expr: "sunset", On: true}, nextFire: now.Add(time.Hour)}
+	s.rules["due"] = due
+	s.rules["not-due"] = notDue
+	heap.Push(&s.pending, due)
+	heap.Push(&s.pending, notDue)
+	s.mu.Unlock()
+
+	s.fireDue()
+
+	if got := apply.callCount(); got != 1 {
+		t.Fatalf("apply called %d times, want 1 (only the due rule)", got)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) != 2 {
+		t.Fatalf("len(pending) = %d, want 2 (fired rule rescheduled)", len(s.pending))
+	}
+	if !s.rules["due"].nextFire.After(now) {
+		t.Errorf("due rule's nextFire = %s, want it rescheduled after %s", s.rules["due"].nextFire, now)
+	}
+}
+
+// TestFireOneDropsStaleRescheduleAfterConcurrentReplace guards against a race where AddSchedule
+// replaces a label's rule while that label's fireOne is blocked in apply: fireOne must not
+// re-push the stale *rule it was holding once a newer one has superseded it in s.rules.
+func TestFireOneDropsStaleRescheduleAfterConcurrentReplace(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	apply := func(label string, on bool) error {
+		close(started)
+		<-release
+		return nil
+	}
+	solar := fakeSolarProvider{sunsetOffset: time.Hour}
+	s, err := NewScheduler(newFakeScheduleStore(), solar, apply)
+	if err != nil {
+		t.Fatalf("NewScheduler: %s", err)
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	if err := s.addRuleLocked(store.ScheduleRule{Label: "porch-light", Expr: "sunset", On: true}, now); err != nil {
+		s.mu.Unlock()
+		t.Fatalf("addRuleLocked: %s", err)
+	}
+	stale := s.rules["porch-light"]
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.fireOne(stale, now)
+		close(done)
+	}()
+
+	<-started // apply is now blocked inside the stale fireOne call.
+	if err := s.AddSchedule("porch-light", "sunset+15m", false); err != nil {
+		t.Fatalf("AddSchedule (replace while apply in flight): %s", err)
+	}
+	close(release)
+	<-done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if got := s.rules["porch-light"]; got.Expr != "sunset+15m" || got.On != false {
+		t.Errorf("rules[porch-light] = %+v, want the replacement (Expr sunset+15m, On false)", got.ScheduleRule)
+	}
+	count := 0
+	for _, r := range s.pending {
+		if r.Label == "porch-light" {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("pending heap has %d entries for porch-light, want 1 (stale reschedule must be dropped)", count)
+	}
+}
+
+func TestRunFiresDueRuleAndWakesOnAddSchedule(t *testing.T) {
+	apply := &recordingApply{}
+	solar := fakeSolarProvider{sunsetOffset: 20 * time.Millisecond}
+	s, err := NewScheduler(newFakeScheduleStore(), solar, apply.apply)
+	if err != nil {
+		t.Fatalf("NewScheduler: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	if err := s.AddSchedule("porch-light", "sunset", true); err != nil {
+		t.Fatalf("AddSchedule: %s", err)
+	}
+
+	deadline := time.After(time.Second)
+	for apply.callCount() < 1 {
+		select {
+		case <-deadline:
+			t.Fatal("Run never fired the due rule within 1s")
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+// Guard against recordingApply's method signature drifting from schedule.Apply.
+var _ Apply = (&recordingApply{}).apply
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// etcdKeyPrefix namespaces this package's keys within a shared etcd
+// cluster that may be used for other purposes too.
+const etcdKeyPrefix = "/tplink-hs100-manager/desired/"
+
+// EtcdStore is a DesiredStateStore backed by etcd v3, allowing multiple
+// Manager instances on different hosts to converge on the same desired
+// state. Desired-state keys are written plain, with no lease attached: the
+// desired state a user set must survive the writing replica crashing, not
+// just its peers', so it must not be tied to any one process's lifetime.
+type EtcdStore struct {
+	client *clientv3.Client
+}
+
+// NewEtcdStore creates an EtcdStore against an already-connected client.
+func NewEtcdStore(client *clientv3.Client) *EtcdStore {
+	return &EtcdStore{client: client}
+}
+
+func (es *EtcdStore) key(label string) string {
+	return etcdKeyPrefix + label
+}
+
+func (es *EtcdStore) label(key string) string {
+	return strings.TrimPrefix(key, etcdKeyPrefix)
+}
+
+// Get implements DesiredStateStore.
+func (es *EtcdStore) Get(label string) (bool, bool) {
+	resp, err := es.client.Get(context.Background(), es.key(label))
+	if err != nil || len(resp.Kvs) == 0 {
+		return false, false
+	}
+	return string(resp.Kvs[0].Value) == "true", true
+}
+
+// List implements DesiredStateStore.
+func (es *EtcdStore) List() map[string]bool {
+	out := make(map[string]bool)
+	resp, err := es.client.Get(context.Background(), etcdKeyPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return out
+	}
+	for _, kv := range resp.Kvs {
+		out[es.label(string(kv.Key))] = string(kv.Value) == "true"
+	}
+	return out
+}
+
+// Set implements DesiredStateStore, writing label's desired state as a
+// plain, leaseless key: it must outlive this replica, not expire alongside
+// it.
+func (es *EtcdStore) Set(label string, on bool) error {
+	value := "false"
+	if on {
+		value = "true"
+	}
+	_, err := es.client.Put(context.Background(), es.key(label), value)
+	if err != nil {
+		return fmt.Errorf("putting desired state for %q: %w", label, err)
+	}
+	return nil
+}
+
+// Watch implements DesiredStateStore, translating etcd's native watch
+// events for keys under etcdKeyPrefix into Events. The returned channel is
+// closed once ctx is done.
+func (es *EtcdStore) Watch(ctx context.Context) <-chan Event {
+	out := make(chan Event, 16)
+	watchChan := es.client.Watch(ctx, etcdKeyPrefix, clientv3.WithPrefix())
+
+	go func() {
+		defer close(out)
+		for resp := range watchChan {
+			for _, ev := range resp.Events {
+				if ev.Type != clientv3.EventTypePut {
+					continue
+				}
+				select {
+				case out <- Event{Label: es.label(string(ev.Kv.Key)), On: string(ev.Kv.Value) == "true"}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"sync"
+)
+
+// FileStore is a DesiredStateStore backed by a single JSON file on disk.
+// Writes are made durable by writing to a temp file in the same directory
+// and renaming over the destination, so a crash mid-write can never leave
+// behind a partially-written file.
+//
+// FileStore only observes changes made through its own Set; it does not
+// watch the file for edits made by other processes. Use the etcd-backed
+// store if multiple Manager processes need to share desired state.
+type FileStore struct {
+	path string
+
+	mu     sync.Mutex
+	states map[string]bool
+
+	watchersMu sync.Mutex
+	watchers   []chan Event
+}
+
+// NewFileStore loads desired state from path, creating an empty store if
+// path does not yet exist.
+func NewFileStore(path string) (*FileStore, error) {
+	fs := &FileStore{
+		path:   path,
+		states: make(map[string]bool),
+	}
+
+	if err := loadJSONFile(path, &fs.states); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// Get implements DesiredStateStore.
+func (fs *FileStore) Get(label string) (bool, bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	on, ok := fs.states[label]
+	return on, ok
+}
+
+// List implements DesiredStateStore.
+func (fs *FileStore) List() map[string]bool {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	out := make(map[string]bool, len(fs.states))
+	for label, on := range fs.states {
+		out[label] = on
+	}
+	return out
+}
+
+// Set implements DesiredStateStore, persisting the new state to disk via a
+// write-to-temp-then-rename before notifying watchers.
+func (fs *FileStore) Set(label string, on bool) error {
+	fs.mu.Lock()
+	fs.states[label] = on
+	err := writeJSONFileAtomic(fs.path, fs.states)
+	fs.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	fs.notify(Event{Label: label, On: on})
+	return nil
+}
+
+// Watch implements DesiredStateStore.
+func (fs *FileStore) Watch(ctx context.Context) <-chan Event {
+	ch := make(chan Event, 16)
+
+	fs.watchersMu.Lock()
+	fs.watchers = append(fs.watchers, ch)
+	fs.watchersMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		fs.watchersMu.Lock()
+		defer fs.watchersMu.Unlock()
+		for i, w := range fs.watchers {
+			if w == ch {
+				fs.watchers = append(fs.watchers[:i], fs.watchers[i+1:]...)
+				break
+			}
+		}
+		close(ch)
+	}()
+
+	return ch
+}
+
+func (fs *FileStore) notify(ev Event) {
+	fs.watchersMu.Lock()
+	defer fs.watchersMu.Unlock()
+	for _, w := range fs.watchers {
+		select {
+		case w <- ev:
+		default:
+			// Slow watcher; drop rather than block Set.
+		}
+	}
+}
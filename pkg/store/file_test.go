@@ -0,0 +1,175 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStoreSetGetList(t *testing.T) {
+	fs, err := NewFileStore(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("NewFileStore: %s", err)
+	}
+
+	if _, ok := fs.Get("plug1"); ok {
+		t.Fatalf("Get on empty store returned ok=true")
+	}
+
+	if err := fs.Set("plug1", true); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+	if err := fs.Set("plug2", false); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+
+	if on, ok := fs.Get("plug1"); !ok || !on {
+		t.Errorf("Get(plug1) = (%v, %v), want (true, true)", on, ok)
+	}
+
+	want := map[string]bool{"plug1": true, "plug2": false}
+	if got := fs.List(); !mapsEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+}
+
+func TestFileStorePersistsAcrossReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	fs, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore: %s", err)
+	}
+	if err := fs.Set("plug1", true); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+
+	reloaded, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore (reload): %s", err)
+	}
+	if on, ok := reloaded.Get("plug1"); !ok || !on {
+		t.Errorf("Get(plug1) after reload = (%v, %v), want (true, true)", on, ok)
+	}
+}
+
+// TestFileStoreSetWritesAtomically checks that the on-disk file is only ever a temp file renamed
+// into place: after Set, no leftover .tmp-* files remain alongside it.
+func TestFileStoreSetWritesAtomically(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	fs, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore: %s", err)
+	}
+	if err := fs.Set("plug1", true); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %s", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "state.json" {
+		t.Fatalf("dir contents = %v, want exactly state.json", entries)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %s", err)
+	}
+	var onDisk map[string]bool
+	if err := json.Unmarshal(data, &onDisk); err != nil {
+		t.Fatalf("unmarshaling on-disk state: %s", err)
+	}
+	if !onDisk["plug1"] {
+		t.Errorf("on-disk state = %v, want plug1=true", onDisk)
+	}
+}
+
+func TestFileStoreWatchReceivesSetAndCleansUpOnCtxDone(t *testing.T) {
+	fs, err := NewFileStore(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("NewFileStore: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := fs.Watch(ctx)
+
+	if err := fs.Set("plug1", true); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+
+	select {
+	case ev := <-ch:
+		if ev.Label != "plug1" || !ev.On {
+			t.Errorf("Watch event = %+v, want {Label: plug1, On: true}", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for watch event")
+	}
+
+	cancel()
+
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("channel delivered an unexpected value instead of closing")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for watch channel to close after ctx cancel")
+	}
+
+	fs.watchersMu.Lock()
+	remaining := len(fs.watchers)
+	fs.watchersMu.Unlock()
+	if remaining != 0 {
+		t.Errorf("watchers left registered after ctx cancel: %d, want 0", remaining)
+	}
+}
+
+func TestFileStoreNotifyDropsOnSlowWatcher(t *testing.T) {
+	fs, err := NewFileStore(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("NewFileStore: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := fs.Watch(ctx) // never drained
+
+	for i := 0; i < 32; i++ {
+		if err := fs.Set("plug1", i%2 == 0); err != nil {
+			t.Fatalf("Set: %s", err)
+		}
+	}
+	// Set must not block despite the unread, bounded watcher channel.
+	drained := 0
+	for {
+		select {
+		case <-ch:
+			drained++
+		default:
+			if drained == 0 {
+				t.Fatal("watcher channel received nothing despite 32 Sets")
+			}
+			return
+		}
+	}
+}
+
+func mapsEqual(a, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
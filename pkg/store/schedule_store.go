@@ -0,0 +1,82 @@
+package store
+
+import "sync"
+
+// ScheduleRule is one persisted schedule.Scheduler rule: apply the desired state On for Label
+// whenever Expr next fires. Expr is either a 6-field (seconds-resolution) cron expression or a
+// solar-relative expression such as "sunset-30m"; see the schedule package for the grammar.
+type ScheduleRule struct {
+	Label string
+	Expr  string
+	On    bool
+}
+
+// ScheduleStore persists a Scheduler's rules, indexed by label, so they survive restart. It
+// mirrors DesiredStateStore's shape: a rule's Set replaces any prior rule for its label. A
+// schedule rule carries an expression and target state alongside its label, which doesn't fit
+// DesiredStateStore's bool-per-label value; ScheduleStore is its own interface for that reason,
+// but FileScheduleStore shares FileStore's underlying JSON load/atomic-write helpers rather than
+// reimplementing them.
+type ScheduleStore interface {
+	// List returns every persisted rule.
+	List() ([]ScheduleRule, error)
+	// Set persists rule, replacing any existing rule for the same label.
+	Set(rule ScheduleRule) error
+	// Delete removes the rule for label, if any.
+	Delete(label string) error
+}
+
+// FileScheduleStore is a ScheduleStore backed by a single JSON file on disk, using the same
+// write-to-temp-then-rename durability as FileStore.
+type FileScheduleStore struct {
+	path string
+
+	mu    sync.Mutex
+	rules map[string]ScheduleRule
+}
+
+// NewFileScheduleStore loads schedule rules from path, creating an empty store if path does not
+// yet exist.
+func NewFileScheduleStore(path string) (*FileScheduleStore, error) {
+	fs := &FileScheduleStore{
+		path:  path,
+		rules: make(map[string]ScheduleRule),
+	}
+
+	if err := loadJSONFile(path, &fs.rules); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// List implements ScheduleStore.
+func (fs *FileScheduleStore) List() ([]ScheduleRule, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	out := make([]ScheduleRule, 0, len(fs.rules))
+	for _, rule := range fs.rules {
+		out = append(out, rule)
+	}
+	return out, nil
+}
+
+// Set implements ScheduleStore.
+func (fs *FileScheduleStore) Set(rule ScheduleRule) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.rules[rule.Label] = rule
+	return fs.persistLocked()
+}
+
+// Delete implements ScheduleStore.
+func (fs *FileScheduleStore) Delete(label string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	delete(fs.rules, label)
+	return fs.persistLocked()
+}
+
+// persistLocked writes fs.rules to fs.path. Callers must hold fs.mu.
+func (fs *FileScheduleStore) persistLocked() error {
+	return writeJSONFileAtomic(fs.path, fs.rules)
+}
@@ -0,0 +1,37 @@
+// Package store provides pluggable, persistent backing for the desired
+// on/off state of a manager.Manager's managed smart plugs, so that desired
+// state survives process restarts and can be shared across Manager
+// instances running on different hosts.
+package store
+
+import "context"
+
+// Event is emitted on a DesiredStateStore's Watch channel whenever a label's
+// desired state changes, whether the change originated from this process
+// (via Set) or from another writer sharing the same backing store.
+type Event struct {
+	Label string
+	On    bool
+}
+
+// DesiredStateStore persists the desired on/off state of managed smart
+// plugs, indexed by label. Implementations must be safe for concurrent use.
+//
+// A Manager treats its DesiredStateStore as the source of truth for desired
+// state, keeping only an in-memory cache of it in sync via Watch, so desired
+// state is durable and, for implementations like the etcd-backed store,
+// shared across Manager instances.
+type DesiredStateStore interface {
+	// Get returns the desired state for label and whether it is present in
+	// the store at all.
+	Get(label string) (on bool, ok bool)
+	// Set persists the desired state for label, notifying any Watch
+	// channels of the change.
+	Set(label string, on bool) error
+	// List returns a snapshot of all desired states currently in the store.
+	List() map[string]bool
+	// Watch returns a channel of Events for desired state changes, both
+	// ones made locally via Set and, for shared stores, ones made by other
+	// writers. The channel is closed once ctx is done.
+	Watch(ctx context.Context) <-chan Event
+}